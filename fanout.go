@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/cenkalti/backoff/v4"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// needsFanOut reports whether logGroups must be partitioned across multiple
+// StartQuery calls, either because it exceeds the CloudWatch Logs Insights
+// cap of 20 log groups per query, or because the user opted into running
+// sub-queries concurrently.
+func needsFanOut(logGroups []string, maxPerQuery, concurrency int) bool {
+	return len(logGroups) > maxPerQuery || concurrency > 1
+}
+
+// chunkLogGroups splits groups into slices of at most size entries, the cap
+// CloudWatch Logs Insights places on a single StartQuery's LogGroupNames.
+func chunkLogGroups(groups []string, size int) [][]string {
+	if size <= 0 || len(groups) <= size {
+		return [][]string{groups}
+	}
+	var chunks [][]string
+	for i := 0; i < len(groups); i += size {
+		end := i + size
+		if end > len(groups) {
+			end = len(groups)
+		}
+		chunks = append(chunks, groups[i:end])
+	}
+	return chunks
+}
+
+// mergedStatistics accumulates QueryStatistics across fanned-out sub-queries.
+type mergedStatistics struct {
+	mu    sync.Mutex
+	stats types.QueryStatistics
+}
+
+func (m *mergedStatistics) add(s *types.QueryStatistics) {
+	if s == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.BytesScanned = aws.Float64(aws.ToFloat64(m.stats.BytesScanned) + aws.ToFloat64(s.BytesScanned))
+	m.stats.RecordsMatched = aws.Float64(aws.ToFloat64(m.stats.RecordsMatched) + aws.ToFloat64(s.RecordsMatched))
+	m.stats.RecordsScanned = aws.Float64(aws.ToFloat64(m.stats.RecordsScanned) + aws.ToFloat64(s.RecordsScanned))
+}
+
+func (m *mergedStatistics) snapshot() *types.QueryStatistics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats
+	return &s
+}
+
+// isThrottled reports whether err is a retryable CloudWatch Logs throttling
+// error rather than a terminal failure worth giving up on immediately.
+func isThrottled(err error) bool {
+	var throttling *types.ThrottlingException
+	var limitExceeded *types.LimitExceededException
+	return errors.As(err, &throttling) || errors.As(err, &limitExceeded)
+}
+
+// startQueryWithBackoff retries StartQuery on throttling using an exponential
+// backoff, since fanning a query out across many log groups is far more
+// likely to trip CloudWatch Logs' per-account rate limit than a single
+// StartQuery.
+func startQueryWithBackoff(ctx context.Context, cl *cloudwatchlogs.Client, in *cloudwatchlogs.StartQueryInput) (*cloudwatchlogs.StartQueryOutput, error) {
+	boff := backoff.NewExponentialBackOff()
+	boff.MaxInterval = 30 * time.Second
+	boff.MaxElapsedTime = 2 * time.Minute
+
+	var out *cloudwatchlogs.StartQueryOutput
+	err := backoff.Retry(func() error {
+		o, err := cl.StartQuery(ctx, in)
+		if err != nil {
+			if isThrottled(err) {
+				return err
+			}
+			return backoff.Permanent(err)
+		}
+		out = o
+		return nil
+	}, backoff.WithContext(boff, ctx))
+	if err != nil {
+		return nil, fmt.Errorf("StartQuery: %w", err)
+	}
+	return out, nil
+}
+
+// getQueryResultsWithBackoff retries GetQueryResults on throttling using the
+// same backoff as startQueryWithBackoff. A fan-out polls far more frequently
+// than it starts queries, so a single ThrottlingException/LimitExceededException
+// while polling must not cancel the whole errgroup.
+func getQueryResultsWithBackoff(ctx context.Context, cl *cloudwatchlogs.Client, in *cloudwatchlogs.GetQueryResultsInput) (*cloudwatchlogs.GetQueryResultsOutput, error) {
+	boff := backoff.NewExponentialBackOff()
+	boff.MaxInterval = 30 * time.Second
+	boff.MaxElapsedTime = 2 * time.Minute
+
+	var out *cloudwatchlogs.GetQueryResultsOutput
+	err := backoff.Retry(func() error {
+		o, err := cl.GetQueryResults(ctx, in)
+		if err != nil {
+			if isThrottled(err) {
+				return err
+			}
+			return backoff.Permanent(err)
+		}
+		out = o
+		return nil
+	}, backoff.WithContext(boff, ctx))
+	if err != nil {
+		return nil, fmt.Errorf("GetQueryResults: %w", err)
+	}
+	return out, nil
+}
+
+// runFanOut partitions logGroups into chunks of at most
+// *optMaxLogGroupsPerQuery log groups, issues one StartQuery per chunk with
+// at most *optConcurrency in flight at a time, and streams every sub-query's
+// rows into enc/w as they complete. If ctx is canceled, or any sub-query
+// fails, StopQuery is issued for every sub-query still outstanding.
+func runFanOut(ctx context.Context, cl *cloudwatchlogs.Client, logGroups []string, q string, st, et time.Time, limit *int32, w io.Writer, enc OutputEncoder) (*types.QueryStatistics, error) {
+	chunks := chunkLogGroups(logGroups, *optMaxLogGroupsPerQuery)
+
+	var writeMu sync.Mutex
+	merged := &mergedStatistics{}
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(*optConcurrency)
+
+	for _, chunk := range chunks {
+		chunk := chunk
+		eg.Go(func() error {
+			out, err := startQueryWithBackoff(egCtx, cl, &cloudwatchlogs.StartQueryInput{
+				StartTime:     aws.Int64(st.Unix()),
+				EndTime:       aws.Int64(et.Unix()),
+				Limit:         limit,
+				LogGroupNames: chunk,
+				QueryString:   aws.String(q),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = pollSubQuery(egCtx, cl, out.QueryId, merged, &writeMu, enc, nil)
+			return err
+		})
+	}
+
+	err := eg.Wait()
+	return merged.snapshot(), err
+}
+
+// pollSubQuery polls a single fanned-out query to completion, merging its
+// statistics into merged and serializing its rows (with extra merged into
+// every row, e.g. a synthetic @window_start) into enc under writeMu so
+// concurrently-polled sub-queries can share one OutputEncoder safely. It
+// returns the number of rows written.
+func pollSubQuery(ctx context.Context, cl *cloudwatchlogs.Client, queryID *string, merged *mergedStatistics, writeMu *sync.Mutex, enc OutputEncoder, extra map[string]string) (int, error) {
+	logger := LoggerFromContext(ctx)
+
+	var done bool
+	defer func() {
+		if !done {
+			stopCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			if _, err := cl.StopQuery(stopCtx, &cloudwatchlogs.StopQueryInput{QueryId: queryID}); err != nil {
+				logger.Warn("StopQuery failed", zap.String("queryId", aws.ToString(queryID)), zap.Error(err))
+			} else {
+				logger.Warn("StopQuery", zap.String("queryId", aws.ToString(queryID)))
+			}
+		}
+	}()
+
+	boff := backoff.NewExponentialBackOff()
+	boff.MaxInterval = time.Second
+	boff.MaxElapsedTime = 0
+	boff.Reset()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		default:
+		}
+
+		pollStart := time.Now()
+		out, err := getQueryResultsWithBackoff(ctx, cl, &cloudwatchlogs.GetQueryResultsInput{QueryId: queryID})
+		if err != nil {
+			return 0, fmt.Errorf("GetQueryResults %s: %w", aws.ToString(queryID), err)
+		}
+
+		logger.Debug("GetQueryResults",
+			zap.String("queryId", aws.ToString(queryID)),
+			zap.String("status", string(out.Status)),
+			zap.Duration("elapsed", time.Since(pollStart)))
+
+		switch out.Status {
+		case types.QueryStatusScheduled, types.QueryStatusRunning:
+			d := boff.NextBackOff()
+			if d == backoff.Stop {
+				return 0, fmt.Errorf("reached backoff.Stop for %s", aws.ToString(queryID))
+			}
+			logger.Debug("backing off", zap.String("queryId", aws.ToString(queryID)), zap.Duration("backoff", d))
+			select {
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			case <-time.After(d):
+			}
+			continue
+		case types.QueryStatusComplete:
+			// Insights statistics are cumulative per query, so only the final
+			// reading (here) must be merged — adding every poll's reading would
+			// massively over-count BytesScanned/RecordsMatched/RecordsScanned.
+			merged.add(out.Statistics)
+			writeMu.Lock()
+			err := writeRows(out.Results, enc, extra)
+			writeMu.Unlock()
+			done = true
+			return len(out.Results), err
+		case types.QueryStatusFailed:
+			done = true
+			return 0, fmt.Errorf("status=%s for %s", out.Status, aws.ToString(queryID))
+		default:
+			return 0, fmt.Errorf("status=%s for %s", out.Status, aws.ToString(queryID))
+		}
+	}
+}
+
+// writeRows fans CloudWatch Logs Insights result rows out to enc.WriteRow,
+// preserving CloudWatch's field order and prepending extra to every row
+// first (e.g. a synthetic @window_start).
+func writeRows(results [][]types.ResultField, enc OutputEncoder, extra map[string]string) error {
+	for _, r := range results {
+		row := make(Row, 0, len(extra)+len(r))
+		for k, v := range extra {
+			row = append(row, RowField{Name: k, Value: v})
+		}
+		for _, e := range r {
+			row = append(row, RowField{Name: *e.Field, Value: *e.Value})
+		}
+		if err := enc.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	optLogLevel  = flag.String("log-level", "info", "log level: debug, info, warn or error")
+	optLogFormat = flag.String("log-format", "text", "log output format: text or json")
+)
+
+type loggerCtxKey struct{}
+
+// WithLogger returns a copy of ctx carrying logger, retrievable via
+// LoggerFromContext.
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, logger)
+}
+
+// LoggerFromContext returns the *zap.Logger stored in ctx by WithLogger, or a
+// no-op logger if none was stored.
+func LoggerFromContext(ctx context.Context) *zap.Logger {
+	if l, ok := ctx.Value(loggerCtxKey{}).(*zap.Logger); ok {
+		return l
+	}
+	return zap.NewNop()
+}
+
+// NewLogger builds the *zap.Logger selected by --log-level/--log-format, so
+// operators running this tool from ECS/Lambda can ingest its own stderr with
+// the same log pipeline used for the logs it queries.
+func NewLogger(level, format string) (*zap.Logger, error) {
+	var lvl zapcore.Level
+	if err := lvl.Set(level); err != nil {
+		return nil, fmt.Errorf("invalid --log-level %q: %w", level, err)
+	}
+
+	var cfg zap.Config
+	switch format {
+	case "json":
+		cfg = zap.NewProductionConfig()
+	case "text", "":
+		cfg = zap.NewDevelopmentConfig()
+	default:
+		return nil, fmt.Errorf("invalid --log-format %q", format)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.OutputPaths = []string{"stderr"}
+	cfg.ErrorOutputPaths = []string{"stderr"}
+
+	logger, err := cfg.Build()
+	if err != nil {
+		return nil, fmt.Errorf("zap Config.Build: %w", err)
+	}
+	return logger, nil
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/xray"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/samber/lo"
+	"go.uber.org/zap"
 )
 
 var version string
@@ -34,12 +35,19 @@ var (
 	optStat      = flag.String("stat", "/dev/stderr", "output last stat")
 	optOut       = flag.String("out", "/dev/stdout", "path/to/result/file")
 	optTraceID   = flag.String("trace-id", "", "trace-id to query, reflect log groups, start/end time and request ids")
+	optFormat    = flag.String("format", "ndjson", "output format of --out: ndjson, csv or parquet")
+	optVars      = varsFlag{}
+	optDryRun    = flag.Bool("dry-run", false, "print the resolved query, log groups and time range without calling StartQuery")
+
+	optMaxLogGroupsPerQuery = flag.Int("max-log-groups-per-query", 20, "max number of log groups per StartQuery, --log-group beyond this are fanned out across multiple queries")
+	optConcurrency          = flag.Int("concurrency", 1, "number of StartQuery to run concurrently when fanning out across log groups")
 )
 
 func main() {
 	flag.Var(&optLogGroups, "log-group", "name of logGroup")
 	flag.Var(optStart, "start", "inclusive start time to query, 2006-01-02T15:04:05Z07:00")
 	flag.Var(optEnd, "end", "inclusive end time to query, 2006-01-02T15:04:05Z07:00")
+	flag.Var(&optVars, "var", "name=value, repeatable, substituted as ${name} in --query before submission")
 
 	flag.Parse()
 
@@ -52,13 +60,22 @@ func main() {
 		return
 	}
 
-	if err := run(); err != nil {
+	logger, err := NewLogger(*optLogLevel, *optLogFormat)
+	if err != nil {
 		log.Fatalf("*** %v", err)
 	}
+	defer logger.Sync()
+
+	ctx := WithLogger(context.Background(), logger)
+	if err := run(ctx); err != nil {
+		logger.Fatal(err.Error())
+	}
 }
 
-func run() error {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+func run(ctx context.Context) error {
+	logger := LoggerFromContext(ctx)
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
 	defer cancel()
 
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(os.Getenv("AWS_REGION")))
@@ -70,15 +87,18 @@ func run() error {
 		return fmt.Errorf("--query must be specified")
 	}
 
-	b, err := os.ReadFile(*optQuery)
-	if err != nil {
-		return fmt.Errorf("os.ReadFile: %v", err)
+	if *optConcurrency < 1 {
+		return fmt.Errorf("--concurrency must be >= 1")
 	}
 
-	q := string(b)
-
 	clLogs := cloudwatchlogs.NewFromConfig(cfg)
 
+	q, err := resolveQuery(ctx, clLogs, *optQuery)
+	if err != nil {
+		return err
+	}
+	q = substituteVars(q, optVars)
+
 	if *optTraceID != "" {
 		clXray := xray.NewFromConfig(cfg)
 		li, err := GatherLogInfo(ctx, clXray, *optTraceID, clLogs)
@@ -100,6 +120,15 @@ func run() error {
 		for _, e := range li.RequestIDs {
 			q = q + fmt.Sprintf(" or @requestId = %q or @message like %q", e, e)
 		}
+		for _, e := range li.ECSTaskARNs {
+			q = q + fmt.Sprintf(" or @logStream like %q or @message like %q", ecsTaskID(e), e)
+		}
+		for _, e := range li.ContainerIDs {
+			q = q + fmt.Sprintf(" or @message like %q", e)
+		}
+		for _, e := range li.FunctionARNs {
+			q = q + fmt.Sprintf(" or @message like %q", e)
+		}
 
 		lo.Must0(optStart.Set(li.StartTime.Format(time.RFC3339)))
 		lo.Must0(optEnd.Set(li.EndTime.Format(time.RFC3339)))
@@ -109,12 +138,6 @@ func run() error {
 		}
 	}
 
-	fp, err := os.Create(*optOut)
-	if err != nil {
-		return fmt.Errorf("os.Create out --out: %v", err)
-	}
-	defer fp.Close()
-
 	st := optStart.Value()
 	et := optEnd.Value()
 
@@ -129,12 +152,62 @@ func run() error {
 		return fmt.Errorf("--end must be after --start")
 	}
 
-	log.Printf("from %s to %s", st.Format(time.RFC3339), et.Format(time.RFC3339))
+	if *optDryRun {
+		fmt.Printf("log groups: %v\n", optLogGroups)
+		fmt.Printf("start: %s\n", st.Format(time.RFC3339))
+		fmt.Printf("end: %s\n", et.Format(time.RFC3339))
+		fmt.Printf("query:\n%s\n", q)
+		return nil
+	}
+
+	enc, err := NewOutputEncoder(*optFormat)
+	if err != nil {
+		return err
+	}
+
+	fp, err := openOutputWriter(ctx, cfg, *optFormat, *optOut)
+	if err != nil {
+		return fmt.Errorf("openOutputWriter: %w", err)
+	}
+	defer fp.Close()
+
+	logger.Info("query window", zap.Time("start", st), zap.Time("end", et))
 	var limit *int32
 	if *optLimit > 0 {
 		i := int32(*optLimit)
 		limit = &i
 	}
+
+	if *optWindow > 0 {
+		if err := enc.Open(fp); err != nil {
+			return fmt.Errorf("OutputEncoder.Open: %w", err)
+		}
+		stats, winErr := runWindowed(ctx, clLogs, optLogGroups, q, st, et, limit, fp, enc)
+		closeErr := enc.Close()
+		if err := writeStatFile(stats); err != nil {
+			return err
+		}
+		if winErr != nil {
+			return winErr
+		}
+		return closeErr
+	}
+
+	if needsFanOut(optLogGroups, *optMaxLogGroupsPerQuery, *optConcurrency) {
+		if err := enc.Open(fp); err != nil {
+			return fmt.Errorf("OutputEncoder.Open: %w", err)
+		}
+		stats, fanErr := runFanOut(ctx, clLogs, optLogGroups, q, st, et, limit, fp, enc)
+		closeErr := enc.Close()
+		if err := writeStatFile(stats); err != nil {
+			return err
+		}
+		if fanErr != nil {
+			return fanErr
+		}
+		return closeErr
+	}
+
 	out, err := clLogs.StartQuery(ctx, &cloudwatchlogs.StartQueryInput{
 		StartTime:     aws.Int64(st.Unix()),
 		EndTime:       aws.Int64(et.Unix()),
@@ -143,17 +216,36 @@ func run() error {
 		QueryString:   aws.String(q),
 	})
 	if err != nil {
-		log.Fatalf("*** StartQueryWithContext: %v", err)
+		return fmt.Errorf("StartQuery: %w", err)
 	}
 
-	if err := getResult(ctx, clLogs, out, fp); err != nil {
+	if err := getResult(ctx, clLogs, out, fp, enc); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func getResult(ctx context.Context, cl *cloudwatchlogs.Client, stOut *cloudwatchlogs.StartQueryOutput, w io.Writer) error {
+// writeStatFile writes stats as the final --stat output, matching the shape
+// getResult writes for a single, non-fanned-out query.
+func writeStatFile(stats *types.QueryStatistics) error {
+	f, err := os.Create(*optStat)
+	if err != nil {
+		return fmt.Errorf("os.Create stat: %w", err)
+	}
+	defer f.Close()
+
+	if stats != nil {
+		if err := json.NewEncoder(f).Encode(stats); err != nil {
+			return fmt.Errorf("json.Encode stat: %w", err)
+		}
+	}
+	return nil
+}
+
+func getResult(ctx context.Context, cl *cloudwatchlogs.Client, stOut *cloudwatchlogs.StartQueryOutput, w io.Writer, enc OutputEncoder) error {
+	logger := LoggerFromContext(ctx)
+
 	var done bool
 	defer func() {
 		if !done {
@@ -162,27 +254,18 @@ func getResult(ctx context.Context, cl *cloudwatchlogs.Client, stOut *cloudwatch
 			defer cancel()
 			out, err := cl.StopQuery(ctx, &cloudwatchlogs.StopQueryInput{QueryId: stOut.QueryId})
 			if err != nil {
-				log.Printf("*** StopQuery: %v", err)
+				logger.Warn("StopQuery failed", zap.Error(err))
 			} else {
-				b, err := json.Marshal(out)
-				if err == nil {
-					log.Printf("stopped: %s", string(b))
-				}
+				logger.Warn("StopQuery", zap.Stringp("queryId", stOut.QueryId), zap.Bool("aborted", aws.ToBool(out.Success)))
 			}
 		}
 	}()
 
-	f, err := os.Create(*optStat)
-	if err != nil {
-		return fmt.Errorf("os.Create stat: %w", err)
-	}
-	defer f.Close()
-
 	var lastStat *types.QueryStatistics
 	defer func() {
-		if lastStat != nil {
-			enc := json.NewEncoder(f)
-			enc.Encode(lastStat)
+		logger.Info("final query statistics", zap.Any("stat", lastStat))
+		if err := writeStatFile(lastStat); err != nil {
+			logger.Error("writeStatFile", zap.Error(err))
 		}
 	}()
 
@@ -190,7 +273,9 @@ func getResult(ctx context.Context, cl *cloudwatchlogs.Client, stOut *cloudwatch
 	boff.MaxInterval = time.Second
 	boff.MaxElapsedTime = 0
 	boff.Reset()
-	enc := json.NewEncoder(w)
+	if err := enc.Open(w); err != nil {
+		return fmt.Errorf("OutputEncoder.Open: %w", err)
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -198,6 +283,7 @@ func getResult(ctx context.Context, cl *cloudwatchlogs.Client, stOut *cloudwatch
 		default:
 		}
 
+		pollStart := time.Now()
 		out, err := cl.GetQueryResults(ctx, &cloudwatchlogs.GetQueryResultsInput{
 			QueryId: stOut.QueryId,
 		})
@@ -206,11 +292,10 @@ func getResult(ctx context.Context, cl *cloudwatchlogs.Client, stOut *cloudwatch
 		}
 		lastStat = out.Statistics
 
-		b, err := json.Marshal(lastStat)
-		if err != nil {
-			return fmt.Errorf("json.Marshal Statistics: %v", err)
-		}
-		log.Printf("status=%s, %s", out.Status, string(b))
+		logger.Debug("GetQueryResults",
+			zap.String("status", string(out.Status)),
+			zap.Any("stat", lastStat),
+			zap.Duration("elapsed", time.Since(pollStart)))
 
 		switch out.Status {
 		case types.QueryStatusScheduled, types.QueryStatusRunning:
@@ -218,6 +303,7 @@ func getResult(ctx context.Context, cl *cloudwatchlogs.Client, stOut *cloudwatch
 			if d == backoff.Stop {
 				return fmt.Errorf("reached backoff.Stop")
 			}
+			logger.Debug("backing off", zap.Duration("backoff", d))
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
@@ -225,17 +311,11 @@ func getResult(ctx context.Context, cl *cloudwatchlogs.Client, stOut *cloudwatch
 			}
 			continue
 		case types.QueryStatusComplete:
-			for _, r := range out.Results {
-				m := map[string]interface{}{}
-				for _, e := range r {
-					m[*e.Field] = *e.Value
-				}
-				if err := enc.Encode(m); err != nil {
-					return fmt.Errorf("json.Encode rec: %w", err)
-				}
+			if err := writeRows(out.Results, enc, nil); err != nil {
+				return err
 			}
 			done = true
-			return nil
+			return enc.Close()
 		case types.QueryStatusFailed:
 			done = true
 			return fmt.Errorf("status=%s", out.Status)
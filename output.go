@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// RowField is a single name/value pair from a CloudWatch Logs Insights
+// result record.
+type RowField struct {
+	Name  string
+	Value string
+}
+
+// Row is a query result row as an ordered list of field/value pairs,
+// preserving the field order CloudWatch Logs Insights returned them in (a
+// map would discard it, since Go randomizes map iteration order).
+type Row []RowField
+
+// Map converts the row to a map, for encoders that don't care about field
+// order.
+func (r Row) Map() map[string]string {
+	m := make(map[string]string, len(r))
+	for _, f := range r {
+		m[f.Name] = f.Value
+	}
+	return m
+}
+
+// OutputEncoder writes query result rows to an underlying destination in a
+// particular format. Open is called once before the first WriteRow, Close
+// once after the last; implementations that buffer records (e.g. to derive
+// a CSV header) flush on Close.
+type OutputEncoder interface {
+	Open(w io.Writer) error
+	WriteRow(row Row) error
+	Close() error
+}
+
+// NewOutputEncoder returns the OutputEncoder for the given --format value.
+func NewOutputEncoder(format string) (OutputEncoder, error) {
+	switch format {
+	case "", "ndjson":
+		return &ndjsonEncoder{}, nil
+	case "csv":
+		return &csvEncoder{}, nil
+	case "parquet":
+		return &parquetEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown --format: %s", format)
+	}
+}
+
+// isS3Path reports whether path points at an S3 object, e.g. s3://bucket/key.
+func isS3Path(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+func splitS3Path(path string) (bucket, key string, err error) {
+	rest := strings.TrimPrefix(path, "s3://")
+	i := strings.Index(rest, "/")
+	if i < 0 || i == 0 || i == len(rest)-1 {
+		return "", "", fmt.Errorf("invalid s3 path, expected s3://bucket/key: %s", path)
+	}
+	return rest[:i], rest[i+1:], nil
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) Open(w io.Writer) error {
+	e.enc = json.NewEncoder(w)
+	return nil
+}
+
+func (e *ndjsonEncoder) WriteRow(row Row) error {
+	if err := e.enc.Encode(row.Map()); err != nil {
+		return fmt.Errorf("json.Encode rec: %w", err)
+	}
+	return nil
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// csvEncoder buffers rows so it can derive a stable header from the first
+// row's field order, then backfills columns discovered in later rows in the
+// order they're first seen (CloudWatch Logs Insights queries such as
+// `fields *` can yield a different field set per record when sparse fields
+// are absent from some log lines).
+type csvEncoder struct {
+	rows   []Row
+	header []string
+	seen   map[string]bool
+	w      io.Writer
+}
+
+func (e *csvEncoder) Open(w io.Writer) error {
+	e.w = w
+	e.seen = map[string]bool{}
+	return nil
+}
+
+func (e *csvEncoder) WriteRow(row Row) error {
+	for _, f := range row {
+		if !e.seen[f.Name] {
+			e.seen[f.Name] = true
+			e.header = append(e.header, f.Name)
+		}
+	}
+	e.rows = append(e.rows, row)
+	return nil
+}
+
+func (e *csvEncoder) Close() error {
+	cw := csv.NewWriter(e.w)
+	if err := cw.Write(e.header); err != nil {
+		return fmt.Errorf("csv.Write header: %w", err)
+	}
+	for _, row := range e.rows {
+		m := row.Map()
+		rec := make([]string, len(e.header))
+		for i, h := range e.header {
+			rec[i] = m[h]
+		}
+		if err := cw.Write(rec); err != nil {
+			return fmt.Errorf("csv.Write rec: %w", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parquetEncoder writes rows via xitongsys/parquet-go. Unlike csvEncoder, its
+// output has a single column, "fields", holding each row's CloudWatch Logs
+// Insights field name/value pairs as a JSON blob (see parquetRowSchema) —
+// queries such as `fields *` that vary their field set per record can't be
+// mapped onto fixed typed Parquet columns ahead of time, so callers reading
+// this format in Athena/DuckDB must `json_extract` the column rather than
+// selecting fields directly. It buffers onto a writerfile backed by w, so
+// callers that need to stream to S3 should pass a writer that forwards to an
+// s3manager upload (see openOutputWriter).
+type parquetEncoder struct {
+	pw *writer.JSONWriter
+}
+
+func (e *parquetEncoder) Open(w io.Writer) error {
+	fw := writerfile.NewWriterFile(w)
+	pw, err := writer.NewJSONWriter(parquetRowSchema, fw, 4)
+	if err != nil {
+		return fmt.Errorf("writer.NewJSONWriter: %w", err)
+	}
+	e.pw = pw
+	return nil
+}
+
+// parquetRowSchema is the single-column schema written by parquetEncoder,
+// required by writer.NewJSONWriter.
+const parquetRowSchema = `{
+	"Tag": "name=root",
+	"Fields": [
+		{"Tag": "name=fields, type=BYTE_ARRAY, convertedtype=UTF8"}
+	]
+}`
+
+func (e *parquetEncoder) WriteRow(row Row) error {
+	fields, err := json.Marshal(row.Map())
+	if err != nil {
+		return fmt.Errorf("json.Marshal rec: %w", err)
+	}
+
+	// parquetRowSchema declares a single "fields" column, so the JSONWriter
+	// expects a record keyed by that column name, not the raw field blob.
+	rec, err := json.Marshal(map[string]string{"fields": string(fields)})
+	if err != nil {
+		return fmt.Errorf("json.Marshal column: %w", err)
+	}
+	if err := e.pw.Write(string(rec)); err != nil {
+		return fmt.Errorf("parquet Write: %w", err)
+	}
+	return nil
+}
+
+func (e *parquetEncoder) Close() error {
+	if err := e.pw.WriteStop(); err != nil {
+		return fmt.Errorf("parquet WriteStop: %w", err)
+	}
+	return nil
+}
+
+// openOutputWriter opens the destination for --out, returning an io.WriteCloser.
+// When format is parquet and path is an s3:// URI, it streams directly to S3
+// via a multipart upload instead of materializing a local file.
+func openOutputWriter(ctx context.Context, cfg aws.Config, format, path string) (io.WriteCloser, error) {
+	if format == "parquet" && isS3Path(path) {
+		bucket, key, err := splitS3Path(path)
+		if err != nil {
+			return nil, err
+		}
+		return newS3PipeWriter(ctx, s3.NewFromConfig(cfg), bucket, key), nil
+	}
+	return os.Create(path)
+}
+
+// s3PipeWriter adapts an io.Pipe to an s3manager multipart upload so rows can
+// be streamed out as they're encoded rather than buffered entirely in memory.
+type s3PipeWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newS3PipeWriter(ctx context.Context, cl *s3.Client, bucket, key string) *s3PipeWriter {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		uploader := manager.NewUploader(cl)
+		_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	return &s3PipeWriter{pw: pw, done: done}
+}
+
+func (s *s3PipeWriter) Write(p []byte) (int, error) {
+	return s.pw.Write(p)
+}
+
+func (s *s3PipeWriter) Close() error {
+	if err := s.pw.Close(); err != nil {
+		return err
+	}
+	return <-s.done
+}
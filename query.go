@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+)
+
+// varsFlag collects repeated --var name=value flags for ${name} substitution
+// in the resolved query text.
+type varsFlag map[string]string
+
+func (f *varsFlag) String() string {
+	if f == nil || *f == nil {
+		return "{}"
+	}
+	return fmt.Sprintf("%v", map[string]string(*f))
+}
+
+func (f *varsFlag) Set(v string) error {
+	name, value, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("--var must be name=value, got %q", v)
+	}
+	if *f == nil {
+		*f = map[string]string{}
+	}
+	(*f)[name] = value
+	return nil
+}
+
+const logsQueryDefinitionPrefix = "logs://"
+
+// resolveQuery loads the query text named by queryArg: "-" reads stdin,
+// "logs://<name>" fetches a saved query definition via
+// DescribeQueryDefinitions, and anything else is treated as a local file
+// path.
+func resolveQuery(ctx context.Context, cl *cloudwatchlogs.Client, queryArg string) (string, error) {
+	switch {
+	case queryArg == "-":
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("io.ReadAll stdin: %w", err)
+		}
+		return string(b), nil
+	case strings.HasPrefix(queryArg, logsQueryDefinitionPrefix):
+		name := strings.TrimPrefix(queryArg, logsQueryDefinitionPrefix)
+		return fetchQueryDefinition(ctx, cl, name)
+	default:
+		b, err := os.ReadFile(queryArg)
+		if err != nil {
+			return "", fmt.Errorf("os.ReadFile: %w", err)
+		}
+		return string(b), nil
+	}
+}
+
+// fetchQueryDefinition looks up a saved CloudWatch Logs query definition by
+// name via DescribeQueryDefinitions, since the API has no get-by-name call.
+func fetchQueryDefinition(ctx context.Context, cl *cloudwatchlogs.Client, name string) (string, error) {
+	out, err := cl.DescribeQueryDefinitions(ctx, &cloudwatchlogs.DescribeQueryDefinitionsInput{
+		QueryDefinitionNamePrefix: &name,
+	})
+	if err != nil {
+		return "", fmt.Errorf("DescribeQueryDefinitions: %w", err)
+	}
+
+	for _, qd := range out.QueryDefinitions {
+		if qd.Name != nil && *qd.Name == name {
+			return *qd.QueryString, nil
+		}
+	}
+	return "", fmt.Errorf("query definition not found: %s", name)
+}
+
+// substituteVars replaces every ${name} occurrence in q with the
+// corresponding value from vars.
+func substituteVars(q string, vars map[string]string) string {
+	for name, value := range vars {
+		q = strings.ReplaceAll(q, "${"+name+"}", value)
+	}
+	return q
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+var optWindow = flag.Duration("window", 0, "slice [--start,--end] into windows of this duration, one StartQuery per window, to bypass the 10000-row Insights limit")
+
+// insightsMaxResults is the number of rows CloudWatch Logs Insights returns
+// from a single query when --limit is not given; it's also the hard ceiling
+// Insights imposes when --limit is given a higher value.
+const insightsMaxResults = 10000
+
+// timeWindow is a single [start,end) slice of the overall query range.
+type timeWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// splitWindows slices [st,et] into consecutive windows of length window. The
+// final window is clipped to et rather than overshooting it.
+func splitWindows(st, et time.Time, window time.Duration) []timeWindow {
+	if window <= 0 {
+		return []timeWindow{{start: st, end: et}}
+	}
+
+	var windows []timeWindow
+	for cur := st; cur.Before(et); cur = cur.Add(window) {
+		end := cur.Add(window)
+		if end.After(et) {
+			end = et
+		}
+		windows = append(windows, timeWindow{start: cur, end: end})
+	}
+	return windows
+}
+
+// runWindowed slices [st,et] into windows of *optWindow and, within each
+// window, partitions logGroups per *optMaxLogGroupsPerQuery the same way
+// runFanOut does, issuing one StartQuery per (window, log-group chunk) pair
+// with at most *optConcurrency in flight at a time. Every row written carries
+// a synthetic @window_start field so downstream consumers can reconstruct
+// ordering across windows.
+func runWindowed(ctx context.Context, cl *cloudwatchlogs.Client, logGroups []string, q string, st, et time.Time, limit *int32, w io.Writer, enc OutputEncoder) (*types.QueryStatistics, error) {
+	logger := LoggerFromContext(ctx)
+
+	windows := splitWindows(st, et, *optWindow)
+	chunks := chunkLogGroups(logGroups, *optMaxLogGroupsPerQuery)
+
+	var writeMu sync.Mutex
+	merged := &mergedStatistics{}
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(*optConcurrency)
+
+	for _, win := range windows {
+		win := win
+		for _, chunk := range chunks {
+			chunk := chunk
+			eg.Go(func() error {
+				// StartQuery's EndTime is inclusive, so stop one second short of
+				// the next window's start to keep windows [start,end).
+				endTime := win.end.Unix()
+				if win.end.Before(et) && endTime > win.start.Unix() {
+					endTime--
+				}
+				out, err := startQueryWithBackoff(egCtx, cl, &cloudwatchlogs.StartQueryInput{
+					StartTime:     aws.Int64(win.start.Unix()),
+					EndTime:       aws.Int64(endTime),
+					Limit:         limit,
+					LogGroupNames: chunk,
+					QueryString:   aws.String(q),
+				})
+				if err != nil {
+					return err
+				}
+
+				extra := map[string]string{"@window_start": win.start.Format(time.RFC3339)}
+				n, err := pollSubQuery(egCtx, cl, out.QueryId, merged, &writeMu, enc, extra)
+				if err != nil {
+					return err
+				}
+
+				effectiveLimit := insightsMaxResults
+				if limit != nil && int(*limit) < effectiveLimit {
+					effectiveLimit = int(*limit)
+				}
+				if n == effectiveLimit {
+					logger.Warn("window returned exactly the result cap, results may be truncated; consider a smaller --window",
+						zap.String("window_start", win.start.Format(time.RFC3339)),
+						zap.Int("limit", effectiveLimit))
+				}
+				return nil
+			})
+		}
+	}
+
+	err := eg.Wait()
+	return merged.snapshot(), err
+}
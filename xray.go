@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -13,14 +14,18 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/xray"
 	"github.com/deckarep/golang-set/v2"
 	"github.com/spyzhov/ajson"
+	"go.uber.org/zap"
 )
 
 type LogInfo struct {
-	TraceIDs   []string
-	RequestIDs []string
-	LogGroups  []string
-	StartTime  time.Time
-	EndTime    time.Time
+	TraceIDs     []string
+	RequestIDs   []string
+	LogGroups    []string
+	ECSTaskARNs  []string
+	ContainerIDs []string
+	FunctionARNs []string
+	StartTime    time.Time
+	EndTime      time.Time
 }
 
 type resetAPIInfo struct {
@@ -29,22 +34,28 @@ type resetAPIInfo struct {
 }
 
 type traverseTraceInfo struct {
-	startTime float64
-	endTime   float64
-	restAPIs  mapset.Set[resetAPIInfo]
-	reqIDs    mapset.Set[string]
-	traceIDs  mapset.Set[string]
-	logGroups mapset.Set[string]
+	startTime    float64
+	endTime      float64
+	restAPIs     mapset.Set[resetAPIInfo]
+	reqIDs       mapset.Set[string]
+	traceIDs     mapset.Set[string]
+	logGroups    mapset.Set[string]
+	ecsTaskARNs  mapset.Set[string]
+	containerIDs mapset.Set[string]
+	functionARNs mapset.Set[string]
 }
 
 func GatherLogInfo(ctx context.Context, cl *xray.Client, tid string, clLogs *cloudwatchlogs.Client) (*LogInfo, error) {
 	ti := traverseTraceInfo{
-		restAPIs:  mapset.NewSet[resetAPIInfo](),
-		reqIDs:    mapset.NewSet[string](),
-		logGroups: mapset.NewSet[string](),
-		traceIDs:  mapset.NewSet[string](),
-		startTime: math.MaxFloat64,
-		endTime:   0,
+		restAPIs:     mapset.NewSet[resetAPIInfo](),
+		reqIDs:       mapset.NewSet[string](),
+		logGroups:    mapset.NewSet[string](),
+		traceIDs:     mapset.NewSet[string](),
+		ecsTaskARNs:  mapset.NewSet[string](),
+		containerIDs: mapset.NewSet[string](),
+		functionARNs: mapset.NewSet[string](),
+		startTime:    math.MaxFloat64,
+		endTime:      0,
 	}
 
 	if err := traverseTrace(ctx, cl, tid, clLogs, &ti); err != nil {
@@ -74,15 +85,29 @@ func GatherLogInfo(ctx context.Context, cl *xray.Client, tid string, clLogs *clo
 	}
 
 	return &LogInfo{
-		TraceIDs:   ti.traceIDs.ToSlice(),
-		RequestIDs: ti.reqIDs.ToSlice(),
-		LogGroups:  ti.logGroups.ToSlice(),
-		StartTime:  time.Unix(int64(ti.startTime)-1, 0),
-		EndTime:    time.Unix(int64(ti.endTime)+1, 0),
+		TraceIDs:     ti.traceIDs.ToSlice(),
+		RequestIDs:   ti.reqIDs.ToSlice(),
+		LogGroups:    ti.logGroups.ToSlice(),
+		ECSTaskARNs:  ti.ecsTaskARNs.ToSlice(),
+		ContainerIDs: ti.containerIDs.ToSlice(),
+		FunctionARNs: ti.functionARNs.ToSlice(),
+		StartTime:    time.Unix(int64(ti.startTime)-1, 0),
+		EndTime:      time.Unix(int64(ti.endTime)+1, 0),
 	}, nil
 
 }
 
+// ecsTaskID extracts the task id (the final path segment) from an ECS task
+// ARN, e.g. arn:aws:ecs:ap-northeast-1:123456789012:task/my-cluster/abcd1234
+// becomes abcd1234, so it can be matched against @logStream in a query.
+func ecsTaskID(arn string) string {
+	i := strings.LastIndex(arn, "/")
+	if i < 0 {
+		return arn
+	}
+	return arn[i+1:]
+}
+
 func atMostOneValue[T any](node *ajson.Node, jp string, funcName string, f func(*ajson.Node) (T, error)) (found *ajson.Node, ret T, err error) {
 	nodes, err := node.JSONPath(jp)
 	if err != nil {
@@ -125,6 +150,8 @@ func multiString(node *ajson.Node, jp string) ([]*ajson.Node, []string, error) {
 }
 
 func traverseTrace(ctx context.Context, cl *xray.Client, tid string, clLogs *cloudwatchlogs.Client, ti *traverseTraceInfo) error {
+	logger := LoggerFromContext(ctx)
+
 	if ti.traceIDs.Contains(tid) {
 		return nil
 	}
@@ -138,6 +165,8 @@ func traverseTrace(ctx context.Context, cl *xray.Client, tid string, clLogs *clo
 
 	ti.traceIDs.Add(tid)
 
+	logger.Debug("BatchGetTraces", zap.String("traceId", tid), zap.Int("segments", len(out.Traces)))
+
 	if len(out.Traces) == 0 {
 		return nil
 	}
@@ -174,6 +203,30 @@ func traverseTrace(ctx context.Context, cl *xray.Client, tid string, clLogs *clo
 					ti.endTime = math.Max(n, ti.endTime)
 				}
 
+				found, v, err = atMostOneString(root, "$.aws.ecs.task_arn")
+				if err != nil {
+					return err
+				}
+				if found != nil {
+					ti.ecsTaskARNs.Add(v)
+				}
+
+				found, v, err = atMostOneString(root, "$.aws.ecs.container")
+				if err != nil {
+					return err
+				}
+				if found != nil {
+					ti.containerIDs.Add(v)
+				}
+
+				found, v, err = atMostOneString(root, "$.aws.function_arn")
+				if err != nil {
+					return err
+				}
+				if found != nil {
+					ti.functionARNs.Add(v)
+				}
+
 				found, v, err = atMostOneString(root, "$.aws.api_gateway.rest_api_id")
 				if err != nil {
 					return err